@@ -0,0 +1,207 @@
+// Package ipam allocates IPv4 addresses for the hosts a run creates.
+//
+// The previous approach in main simply did ip[3] += byte(i), which only
+// works for a /24 or smaller subnet and breaks as soon as a replica count
+// pushes the last octet past 255. Allocator instead walks the address space
+// as a big.Int so it can cross octet boundaries correctly for any subnet
+// size, while skipping the network/broadcast/gateway addresses and any
+// configured exclusions.
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// ErrPoolExhausted is returned by Next when no more usable addresses remain
+// in the configured range.
+var ErrPoolExhausted = errors.New("ipam: address pool exhausted")
+
+// Config describes the subnet and range an Allocator should hand addresses
+// out of.
+type Config struct {
+	// StartIP is the first candidate address to hand out.
+	StartIP string
+	// Netmask is the dotted-decimal subnet mask, e.g. "255.255.254.0".
+	Netmask string
+	// Gateway is excluded from allocation.
+	Gateway string
+	// CIDR, if set, overrides Netmask for determining subnet boundaries
+	// (e.g. "10.0.0.0/23").
+	CIDR string
+	// PoolEnd, if set, is the last address (inclusive) that may be handed
+	// out; StartIP onward is otherwise bounded only by the subnet.
+	PoolEnd string
+	// Exclude lists individual addresses that must never be allocated,
+	// e.g. addresses reserved for other infrastructure.
+	Exclude []string
+	// HostOverrides maps a hostname to an explicit IP address that Allocate
+	// will hand that host instead of drawing from the sequential pool. Every
+	// address here is reserved up front so Next never hands the same address
+	// to a different host.
+	HostOverrides map[string]string
+}
+
+// Allocator hands out IPv4 addresses from a subnet in order, skipping
+// network/broadcast/gateway addresses and any configured exclusions.
+type Allocator struct {
+	network   *net.IPNet
+	gateway   net.IP
+	next      *big.Int
+	poolEnd   *big.Int
+	excluded  map[string]bool
+	overrides map[string]bool
+}
+
+// New builds an Allocator from cfg, validating that StartIP and Gateway fall
+// within the resulting subnet.
+func New(cfg Config) (*Allocator, error) {
+	var network *net.IPNet
+	if cfg.CIDR != "" {
+		_, n, err := net.ParseCIDR(cfg.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("ipam: invalid cidr %q: %s", cfg.CIDR, err)
+		}
+		network = n
+	} else {
+		startIP := net.ParseIP(cfg.StartIP)
+		if startIP == nil {
+			return nil, fmt.Errorf("ipam: invalid start_ip %q", cfg.StartIP)
+		}
+		maskIP := net.ParseIP(cfg.Netmask)
+		if maskIP == nil {
+			return nil, fmt.Errorf("ipam: invalid netmask %q", cfg.Netmask)
+		}
+		mask := net.IPMask(maskIP.To4())
+		network = &net.IPNet{IP: startIP.Mask(mask), Mask: mask}
+	}
+
+	gateway := net.ParseIP(cfg.Gateway)
+	if gateway == nil {
+		return nil, fmt.Errorf("ipam: invalid gateway %q", cfg.Gateway)
+	}
+	if !network.Contains(gateway) {
+		return nil, fmt.Errorf("ipam: gateway %s is not in subnet %s", cfg.Gateway, network)
+	}
+
+	startIP := net.ParseIP(cfg.StartIP)
+	if startIP == nil {
+		return nil, fmt.Errorf("ipam: invalid start_ip %q", cfg.StartIP)
+	}
+	if !network.Contains(startIP) {
+		return nil, fmt.Errorf("ipam: start_ip %s is not in subnet %s", cfg.StartIP, network)
+	}
+
+	excluded := map[string]bool{
+		network.IP.String():         true,
+		broadcast(network).String(): true,
+		gateway.String():            true,
+	}
+	for _, addr := range cfg.Exclude {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("ipam: invalid exclude address %q", addr)
+		}
+		excluded[ip.To4().String()] = true
+	}
+
+	overrides := map[string]bool{}
+	for _, addr := range cfg.HostOverrides {
+		if addr == "" {
+			continue
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("ipam: invalid host override address %q", addr)
+		}
+		overrides[ip.To4().String()] = true
+	}
+
+	a := &Allocator{
+		network:   network,
+		gateway:   gateway,
+		next:      ipToInt(startIP),
+		excluded:  excluded,
+		overrides: overrides,
+	}
+
+	if cfg.PoolEnd != "" {
+		poolEnd := net.ParseIP(cfg.PoolEnd)
+		if poolEnd == nil {
+			return nil, fmt.Errorf("ipam: invalid pool end %q", cfg.PoolEnd)
+		}
+		if !network.Contains(poolEnd) {
+			return nil, fmt.Errorf("ipam: pool end %s is not in subnet %s", cfg.PoolEnd, network)
+		}
+		a.poolEnd = ipToInt(poolEnd)
+	} else {
+		a.poolEnd = ipToInt(broadcast(network))
+		a.poolEnd.Sub(a.poolEnd, big.NewInt(1))
+	}
+
+	return a, nil
+}
+
+// Next returns the next usable address in the range, advancing the
+// allocator past it. It returns ErrPoolExhausted once the range or the
+// subnet is used up. Addresses reserved via Config.HostOverrides are skipped
+// so a sequentially-allocated host never collides with an overridden one.
+func (a *Allocator) Next() (net.IP, error) {
+	for a.next.Cmp(a.poolEnd) <= 0 {
+		candidate := intToIP(a.next)
+		a.next = new(big.Int).Add(a.next, big.NewInt(1))
+
+		if !a.network.Contains(candidate) {
+			continue
+		}
+		if a.excluded[candidate.String()] || a.overrides[candidate.String()] {
+			continue
+		}
+		return candidate, nil
+	}
+	return nil, ErrPoolExhausted
+}
+
+// Allocate returns override parsed as an IP if given, otherwise the next
+// address from Next. It lets per-hostname overrides in YAML take priority
+// over the sequential pool while still validating the address is usable.
+func (a *Allocator) Allocate(override string) (net.IP, error) {
+	if override == "" {
+		return a.Next()
+	}
+
+	ip := net.ParseIP(override)
+	if ip == nil {
+		return nil, fmt.Errorf("ipam: invalid override address %q", override)
+	}
+	if !a.network.Contains(ip) {
+		return nil, fmt.Errorf("ipam: override address %s is not in subnet %s", override, a.network)
+	}
+	if a.excluded[ip.String()] {
+		return nil, fmt.Errorf("ipam: override address %s is reserved (network/broadcast/gateway/excluded)", override)
+	}
+	return ip, nil
+}
+
+func broadcast(network *net.IPNet) net.IP {
+	ip := network.IP.To4()
+	mask := network.Mask
+	bcast := make(net.IP, len(ip))
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To4())
+}
+
+func intToIP(i *big.Int) net.IP {
+	b := i.Bytes()
+	ip := make(net.IP, 4)
+	copy(ip[4-len(b):], b)
+	return ip
+}
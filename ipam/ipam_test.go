@@ -0,0 +1,146 @@
+package ipam
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAllocatorNextSkipsReservedAddresses(t *testing.T) {
+	a, err := New(Config{
+		StartIP: "10.0.0.1",
+		Netmask: "255.255.255.0",
+		Gateway: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	got, err := a.Next()
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	// .0 is the network address, .1 is the gateway: the first usable
+	// address is .2.
+	if want := net.ParseIP("10.0.0.2").To4(); !got.Equal(want) {
+		t.Errorf("Next() = %s, want %s", got, want)
+	}
+}
+
+func TestAllocatorNextCrossesOctetBoundary(t *testing.T) {
+	a, err := New(Config{
+		StartIP: "10.0.0.254",
+		CIDR:    "10.0.0.0/23",
+		Gateway: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	for _, want := range []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"} {
+		got, err := a.Next()
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if wantIP := net.ParseIP(want).To4(); !got.Equal(wantIP) {
+			t.Errorf("Next() = %s, want %s", got, wantIP)
+		}
+	}
+}
+
+func TestAllocatorNextRespectsPoolEnd(t *testing.T) {
+	a, err := New(Config{
+		StartIP: "10.0.0.2",
+		Netmask: "255.255.255.0",
+		Gateway: "10.0.0.1",
+		PoolEnd: "10.0.0.3",
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Next(); err != nil {
+			t.Fatalf("Next() #%d: %s", i, err)
+		}
+	}
+	if _, err := a.Next(); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Next() past pool end = %v, want ErrPoolExhausted", err)
+	}
+}
+
+func TestAllocatorNextSkipsExclude(t *testing.T) {
+	a, err := New(Config{
+		StartIP: "10.0.0.2",
+		Netmask: "255.255.255.0",
+		Gateway: "10.0.0.1",
+		Exclude: []string{"10.0.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	got, err := a.Next()
+	if err != nil {
+		t.Fatalf("Next: %s", err)
+	}
+	if want := net.ParseIP("10.0.0.3").To4(); !got.Equal(want) {
+		t.Errorf("Next() = %s, want %s", got, want)
+	}
+}
+
+func TestAllocatorAllocateOverrideReservesAddress(t *testing.T) {
+	a, err := New(Config{
+		StartIP:       "10.0.0.2",
+		Netmask:       "255.255.255.0",
+		Gateway:       "10.0.0.1",
+		HostOverrides: map[string]string{"esxi01": "10.0.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	overridden, err := a.Allocate("10.0.0.2")
+	if err != nil {
+		t.Fatalf("Allocate(override): %s", err)
+	}
+	if want := net.ParseIP("10.0.0.2").To4(); !overridden.Equal(want) {
+		t.Fatalf("Allocate(override) = %s, want %s", overridden, want)
+	}
+
+	// The override address must not be handed out again to a sequentially
+	// allocated host.
+	next, err := a.Allocate("")
+	if err != nil {
+		t.Fatalf("Allocate(\"\"): %s", err)
+	}
+	if next.Equal(overridden) {
+		t.Fatalf("Allocate(\"\") returned the reserved override address %s", next)
+	}
+}
+
+func TestAllocatorAllocateRejectsReservedOverride(t *testing.T) {
+	a, err := New(Config{
+		StartIP: "10.0.0.2",
+		Netmask: "255.255.255.0",
+		Gateway: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	if _, err := a.Allocate("10.0.0.1"); err == nil {
+		t.Fatal("Allocate(gateway) = nil error, want error")
+	}
+}
+
+func TestNewRejectsGatewayOutsideSubnet(t *testing.T) {
+	_, err := New(Config{
+		StartIP: "10.0.0.2",
+		Netmask: "255.255.255.0",
+		Gateway: "10.0.1.1",
+	})
+	if err == nil {
+		t.Fatal("New() = nil error, want error for out-of-subnet gateway")
+	}
+}
@@ -0,0 +1,316 @@
+// Package kickstart talks to the kickstart server that receives per-host
+// install registrations ahead of boot and serves the uploaded ESXi ISOs.
+// The previous sendApiRequest/decideGuestId functions in main used
+// http.Get/http.Post directly: no timeout, no auth, no TLS configuration,
+// no retries, and any non-2xx response was logged but otherwise treated as
+// success. Client replaces them with a configurable, retrying HTTP client
+// that returns typed errors and caches the ISO-to-GuestId lookup.
+package kickstart
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config configures how a Client reaches and authenticates to a kickstart
+// server.
+type Config struct {
+	BaseURL string `yaml:"base_url" json:"base_url"`
+	// BearerToken, sent as an "Authorization: Bearer" header, and
+	// ClientCert/ClientKey, for mTLS, are alternative ways to authenticate;
+	// BearerToken wins if both are set.
+	BearerToken string `yaml:"bearer_token" json:"bearer_token"`
+	ClientCert  string `yaml:"client_cert" json:"client_cert"`
+	ClientKey   string `yaml:"client_key" json:"client_key"`
+	// CABundle, if set, verifies the server certificate against this PEM
+	// bundle instead of the system root pool.
+	CABundle string `yaml:"ca_bundle" json:"ca_bundle"`
+	// InsecureSkipVerify disables TLS certificate verification. For lab use
+	// only.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	// TimeoutSeconds bounds every individual HTTP request; it defaults to
+	// 30 seconds.
+	TimeoutSeconds int `yaml:"timeout_seconds" json:"timeout_seconds"`
+	// Retry controls retries on 5xx responses and connection errors; it
+	// defaults to DefaultRetryPolicy.
+	Retry *RetryPolicy `yaml:"retry" json:"retry"`
+}
+
+// RetryPolicy controls how many times a request is retried, and how long to
+// wait between attempts, before it is treated as a failure.
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"max_attempts" json:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay" json:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay" json:"max_delay"`
+}
+
+// DefaultRetryPolicy is applied when Config.Retry is unset.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// ResponseError is returned when the kickstart server answers a request
+// with a non-2xx status that isn't treated as success (a 404 on
+// DeregisterHost is treated as success, not an error).
+type ResponseError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("kickstart server: %s %s: %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// RequestBody is the per-host payload registered with, and later
+// deregistered from, the kickstart server.
+type RequestBody struct {
+	Macaddress    string   `json:"macaddress"`
+	Password      string   `json:"password"`
+	Hostname      string   `json:"hostname"`
+	IP            string   `json:"ip"`
+	Netmask       string   `json:"netmask"`
+	Gateway       string   `json:"gateway"`
+	Nameserver    string   `json:"nameserver"`
+	Vlanid        int      `json:"vlanid"`
+	Keyboard      string   `json:"keyboard"`
+	Isofilename   string   `json:"isofilename"`
+	Cli           []string `json:"cli"`
+	NotVmPgCreate bool     `json:"notvmpgcreate"`
+}
+
+// EsxiVersionResponse is the /esxi-versions response body: a map of
+// uploaded ISO filename to the ESXi version it contains.
+type EsxiVersionResponse struct {
+	UploadedFiles map[string]string `json:"uploaded_esxi_list"`
+}
+
+// Client registers and deregisters hosts with a kickstart server ahead of
+// their install, and resolves the govmomi GuestId for an uploaded ISO.
+type Client struct {
+	baseURL string
+	token   string
+	retry   RetryPolicy
+	http    *http.Client
+
+	mu       sync.Mutex
+	versions *EsxiVersionResponse
+	guestIDs map[string]string
+}
+
+// New builds a Client from cfg.
+func New(cfg Config) (*Client, error) {
+	timeout := 30 * time.Second
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	retry := DefaultRetryPolicy
+	if cfg.Retry != nil {
+		retry = *cfg.Retry
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("kickstart: failed to read ca_bundle %q: %s", cfg.CABundle, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kickstart: no certificates found in ca_bundle %q", cfg.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("kickstart: failed to load client cert/key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &Client{
+		baseURL:  strings.TrimSuffix(cfg.BaseURL, "/"),
+		token:    cfg.BearerToken,
+		retry:    retry,
+		http:     &http.Client{Timeout: timeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		guestIDs: make(map[string]string),
+	}, nil
+}
+
+// RegisterHost POSTs body to /ks ahead of a host's install.
+func (c *Client) RegisterHost(ctx context.Context, body RequestBody) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("kickstart: failed to marshal request body: %s", err)
+	}
+	_, err = c.do(ctx, http.MethodPost, "/ks", payload, false)
+	return err
+}
+
+// DeregisterHost DELETEs a host's kickstart registration by MAC address. A
+// 404 is treated as success, since the goal state - no registration left
+// for this host - is already satisfied.
+func (c *Client) DeregisterHost(ctx context.Context, macaddress string) error {
+	subPath := strings.ReplaceAll(macaddress, ":", "-")
+	_, err := c.do(ctx, http.MethodDelete, "/ks/"+subPath, nil, true)
+	return err
+}
+
+// Versions returns the kickstart server's uploaded ESXi ISOs, fetching them
+// from /esxi-versions on first call and caching the result for the life of
+// the Client so concurrent replicas share one round trip.
+func (c *Client) Versions(ctx context.Context) (*EsxiVersionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.versions != nil {
+		return c.versions, nil
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/esxi-versions", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var versions EsxiVersionResponse
+	if err := json.Unmarshal(resp, &versions); err != nil {
+		return nil, fmt.Errorf("kickstart: failed to decode /esxi-versions response: %s", err)
+	}
+	c.versions = &versions
+	return c.versions, nil
+}
+
+// GuestID resolves the govmomi GuestId string for isofilename, caching the
+// result so concurrent replicas installing from the same ISO only do the
+// version lookup once.
+func (c *Client) GuestID(ctx context.Context, isofilename string) (string, error) {
+	c.mu.Lock()
+	if id, ok := c.guestIDs[isofilename]; ok {
+		c.mu.Unlock()
+		return id, nil
+	}
+	c.mu.Unlock()
+
+	versions, err := c.Versions(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var esxiVersion string
+	for filename, version := range versions.UploadedFiles {
+		if filename == isofilename {
+			esxiVersion = version
+		}
+	}
+
+	var guestID string
+	switch esxiVersion {
+	case "":
+		return "", fmt.Errorf("kickstart: iso file %q could not be found on kickstart server", isofilename)
+	case "6.0.0":
+		guestID = "vmkernel6Guest"
+	case "6.5.0", "6.7.0":
+		guestID = "vmkernel65Guest"
+	default:
+		guestID = "vmkernel7Guest"
+	}
+
+	c.mu.Lock()
+	c.guestIDs[isofilename] = guestID
+	c.mu.Unlock()
+
+	return guestID, nil
+}
+
+// do sends method/path with an optional JSON body, retrying on 5xx
+// responses and connection errors with an exponential backoff. When
+// idempotentDelete is set, a 404 response is treated as success rather
+// than a ResponseError.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, idempotentDelete bool) ([]byte, error) {
+	var lastErr error
+	for attempt := 1; attempt <= c.retry.MaxAttempts; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("kickstart: failed to build request: %s", err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("kickstart: %s %s: %s", method, path, err)
+			if !c.backoff(ctx, attempt) {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		respBody, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			lastErr = fmt.Errorf("kickstart: %s %s: failed to read response body: %s", method, path, readErr)
+			if !c.backoff(ctx, attempt) {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		if idempotentDelete && resp.StatusCode == http.StatusNotFound {
+			return respBody, nil
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return respBody, nil
+		}
+
+		respErr := &ResponseError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status, Body: string(respBody)}
+		if resp.StatusCode < 500 {
+			return nil, respErr
+		}
+		lastErr = respErr
+		if !c.backoff(ctx, attempt) {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// backoff waits an exponential delay before the next retry attempt,
+// returning false (without waiting) once attempt has exhausted
+// MaxAttempts or ctx is cancelled.
+func (c *Client) backoff(ctx context.Context, attempt int) bool {
+	if attempt >= c.retry.MaxAttempts {
+		return false
+	}
+	delay := c.retry.BaseDelay << (attempt - 1)
+	if delay > c.retry.MaxDelay {
+		delay = c.retry.MaxDelay
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
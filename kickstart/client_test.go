@@ -0,0 +1,117 @@
+package kickstart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	t.Helper()
+	c, err := New(Config{
+		BaseURL:     baseURL,
+		BearerToken: "s3cr3t",
+		Retry:       &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	return c
+}
+
+func TestClientDoSendsBearerToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cr3t" {
+			t.Errorf("Authorization header = %q, want %q", got, "Bearer s3cr3t")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	if _, err := c.do(context.Background(), http.MethodGet, "/esxi-versions", nil, false); err != nil {
+		t.Fatalf("do: %s", err)
+	}
+}
+
+func TestClientDoRetriesOn5xxThenSucceeds(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	if _, err := c.do(context.Background(), http.MethodGet, "/esxi-versions", nil, false); err != nil {
+		t.Fatalf("do: %s", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestClientDoDoesNotRetryOn4xx(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	_, err := c.do(context.Background(), http.MethodGet, "/esxi-versions", nil, false)
+	if err == nil {
+		t.Fatal("do() = nil error, want error")
+	}
+	respErr, ok := err.(*ResponseError)
+	if !ok {
+		t.Fatalf("do() error type = %T, want *ResponseError", err)
+	}
+	if respErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("StatusCode = %d, want %d", respErr.StatusCode, http.StatusBadRequest)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("attempts = %d, want 1 (no retry on 4xx)", got)
+	}
+}
+
+func TestClientDoIdempotentDeleteTreats404AsSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	if _, err := c.do(context.Background(), http.MethodDelete, "/ks/aa-bb", nil, true); err != nil {
+		t.Fatalf("do(idempotentDelete=true) = %v, want nil error on 404", err)
+	}
+
+	if _, err := c.do(context.Background(), http.MethodDelete, "/ks/aa-bb", nil, false); err == nil {
+		t.Fatal("do(idempotentDelete=false) = nil error, want error on 404")
+	}
+}
+
+func TestClientDoExhaustsRetriesOn5xx(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv.URL)
+	_, err := c.do(context.Background(), http.MethodGet, "/esxi-versions", nil, false)
+	if err == nil {
+		t.Fatal("do() = nil error, want error after exhausting retries")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3 (RetryPolicy.MaxAttempts)", got)
+	}
+}
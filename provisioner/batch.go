@@ -0,0 +1,84 @@
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kickstart-client/ipam"
+)
+
+// ExpandHosts turns a single ESXiConfig into the concrete list of HostSpecs
+// it describes: it expands EsxiInfo.NamePrefix/Replica into hostnames and
+// allocates each one an IP address, applying HostOverrides first. Both the
+// one-shot CLI and the serve subcommand's batch-submission endpoint call
+// this so a batch is expanded identically either way.
+func ExpandHosts(cfg ESXiConfig, changeMac bool) ([]HostSpec, error) {
+	// runSwitchPortGroup always moves vmParam.Networks[0] onto its real
+	// network, regardless of source mode, so every mode needs at least one
+	// entry here even though "clone" and "ovf"/"ova" don't otherwise read
+	// vmParam.Networks to build the VM itself.
+	if len(cfg.VmParameter.Networks) == 0 {
+		return nil, fmt.Errorf("vmparameter requires at least one entry in networks")
+	}
+
+	allocator, err := validateNetworkAddr(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("validate network address: %s", err)
+	}
+	n, hostNamePrefix, err := calcurateNamePrefix(cfg.EsxiInfo.NamePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]HostSpec, 0, cfg.EsxiInfo.Replica)
+	for i, j := 0, n; i < cfg.EsxiInfo.Replica; i, j = i+1, j+1 {
+		hostname := fmt.Sprintf(hostNamePrefix+"."+cfg.EsxiInfo.Domain, j)
+		ip, err := allocator.Allocate(cfg.EsxiInfo.HostOverrides[hostname])
+		if err != nil {
+			return nil, fmt.Errorf("allocate IP for %s: %s", hostname, err)
+		}
+		specs = append(specs, HostSpec{Hostname: hostname, IP: ip, Config: cfg, ChangeMac: changeMac})
+	}
+	return specs, nil
+}
+
+// validateNetworkAddr builds the IP allocator for cfg, which as a side
+// effect validates that start_ip, gateway and pool_end/cidr all agree on
+// the same subnet.
+func validateNetworkAddr(cfg ESXiConfig) (*ipam.Allocator, error) {
+	esxiInfo := cfg.EsxiInfo
+	return ipam.New(ipam.Config{
+		StartIP:       esxiInfo.StartIP,
+		Netmask:       esxiInfo.Netmask,
+		Gateway:       esxiInfo.Gateway,
+		CIDR:          esxiInfo.Cidr,
+		PoolEnd:       esxiInfo.PoolEnd,
+		Exclude:       esxiInfo.Exclude,
+		HostOverrides: esxiInfo.HostOverrides,
+	})
+}
+
+func calcurateNamePrefix(namePrefix string) (int, string, error) {
+	parts := strings.Split(namePrefix, "{")
+	prefix := parts[0]
+
+	parts = strings.Split(parts[1], "}")
+	formatDetails := parts[0]
+
+	parts = strings.Split(formatDetails, ",")
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("could not convert n to integer, check the name_prefix field: %s", err)
+	}
+	padding := 0
+	if len(parts) > 1 {
+		paddingStr := strings.Split(parts[1], "=")[1]
+		padding, err = strconv.Atoi(paddingStr)
+		if err != nil {
+			return 0, "", fmt.Errorf("could not convert fixed=n to integer, check the name_prefix field: %s", err)
+		}
+	}
+
+	return n, prefix + fmt.Sprintf("%%0%dd", padding), nil
+}
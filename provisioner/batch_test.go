@@ -0,0 +1,96 @@
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalcurateNamePrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		namePrefix string
+		wantN      int
+		wantFormat string
+		wantErr    bool
+	}{
+		{name: "fixed padding", namePrefix: "esxi-{1,fixed=2}", wantN: 1, wantFormat: "esxi-%02d"},
+		{name: "no padding", namePrefix: "esxi-{5}", wantN: 5, wantFormat: "esxi-%00d"},
+		{name: "non-numeric start", namePrefix: "esxi-{x}", wantErr: true},
+		{name: "non-numeric padding", namePrefix: "esxi-{1,fixed=x}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, format, err := calcurateNamePrefix(tt.namePrefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("calcurateNamePrefix(%q) = nil error, want error", tt.namePrefix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("calcurateNamePrefix(%q): %s", tt.namePrefix, err)
+			}
+			if n != tt.wantN || format != tt.wantFormat {
+				t.Errorf("calcurateNamePrefix(%q) = (%d, %q), want (%d, %q)", tt.namePrefix, n, format, tt.wantN, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func validESXiConfig() ESXiConfig {
+	return ESXiConfig{
+		EsxiInfo: EsxiInfo{
+			Replica:    2,
+			StartIP:    "10.0.0.2",
+			Netmask:    "255.255.255.0",
+			Gateway:    "10.0.0.1",
+			NamePrefix: "esxi-{1,fixed=2}",
+			Domain:     "example.com",
+		},
+		VmParameter: VmParameter{
+			Networks: []NicParameter{{Network: "VM Network"}},
+		},
+	}
+}
+
+func TestExpandHostsRejectsEmptyNetworks(t *testing.T) {
+	cfg := validESXiConfig()
+	cfg.VmParameter.Networks = nil
+
+	if _, err := ExpandHosts(cfg, false); err == nil {
+		t.Fatal("ExpandHosts(no networks) = nil error, want error")
+	}
+}
+
+func TestExpandHostsAssignsSequentialIPs(t *testing.T) {
+	specs, err := ExpandHosts(validESXiConfig(), false)
+	if err != nil {
+		t.Fatalf("ExpandHosts: %s", err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("len(specs) = %d, want 2", len(specs))
+	}
+	if !strings.HasPrefix(specs[0].Hostname, "esxi-01") {
+		t.Errorf("specs[0].Hostname = %q, want prefix %q", specs[0].Hostname, "esxi-01")
+	}
+	if specs[0].IP.String() == specs[1].IP.String() {
+		t.Errorf("specs[0].IP and specs[1].IP are both %s, want distinct addresses", specs[0].IP)
+	}
+}
+
+func TestExpandHostsAppliesHostOverride(t *testing.T) {
+	cfg := validESXiConfig()
+	cfg.EsxiInfo.HostOverrides = map[string]string{"esxi-01.example.com": "10.0.0.50"}
+
+	specs, err := ExpandHosts(cfg, false)
+	if err != nil {
+		t.Fatalf("ExpandHosts: %s", err)
+	}
+	if specs[0].IP.String() != "10.0.0.50" {
+		t.Errorf("specs[0].IP = %s, want overridden 10.0.0.50", specs[0].IP)
+	}
+	if specs[1].IP.String() == "10.0.0.50" {
+		t.Errorf("specs[1].IP = %s, want the override reserved for esxi-01 only", specs[1].IP)
+	}
+}
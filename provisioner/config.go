@@ -0,0 +1,106 @@
+// Package provisioner provisions ESXi hosts against vCenter: building or
+// cloning the VM, waiting for the kickstart install to report an IP, and
+// moving the boot NIC onto its real network. It is consumed both by the
+// one-shot CLI and by the serve subcommand's HTTP API, via the Driver
+// interface, so neither caller needs to know the vSphere details directly.
+package provisioner
+
+import "kickstart-client/kickstart"
+
+// ESXiConfig is the root of a host-provisioning request: where to connect,
+// what the VM should look like, and how the resulting ESXi install should be
+// configured.
+type ESXiConfig struct {
+	Environment Environment `yaml:"environment" json:"environment"`
+	EsxiInfo    EsxiInfo    `yaml:"esxiInfo" json:"esxiInfo"`
+	VmParameter VmParameter `yaml:"vmparameter" json:"vmparameter"`
+}
+
+type Environment struct {
+	Vcenter         VcenterInfo      `yaml:"vcenter" json:"vcenter"`
+	KickstartServer kickstart.Config `yaml:"kickstartserver" json:"kickstartserver"`
+	BootPortGroup   string           `yaml:"bootportgroup" json:"bootportgroup"`
+}
+
+// VcenterInfo is the vCenter endpoint and credentials a Driver connects
+// with to provision or destroy a host.
+type VcenterInfo struct {
+	Hostname     string `yaml:"hostname" json:"hostname"`
+	Username     string `yaml:"username" json:"username"`
+	Password     string `yaml:"password" json:"password"`
+	Datacenter   string `yaml:"datacenter" json:"datacenter"`
+	ResourcePool string `yaml:"resourcepool" json:"resourcepool"`
+	Folder       string `yaml:"folder" json:"folder"`
+}
+
+type EsxiInfo struct {
+	Replica int      `yaml:"replica" json:"replica"`
+	StartIP string   `yaml:"start_ip" json:"start_ip"`
+	Netmask string   `yaml:"netmask" json:"netmask"`
+	Gateway string   `yaml:"gateway" json:"gateway"`
+	Cidr    string   `yaml:"cidr" json:"cidr"`
+	PoolEnd string   `yaml:"pool_end" json:"pool_end"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+	// HostOverrides maps a computed hostname to an explicit IP address,
+	// taking priority over the sequential allocation from the pool.
+	HostOverrides map[string]string `yaml:"host_overrides" json:"host_overrides"`
+	NamePrefix    string            `yaml:"name_prefix" json:"name_prefix"`
+	Domain        string            `yaml:"domain" json:"domain"`
+	Password      string            `yaml:"password" json:"password"`
+	Nameserver    string            `yaml:"nameserver" json:"nameserver"`
+	Vlanid        int               `yaml:"vlanid" json:"vlanid"`
+	Keyboard      string            `yaml:"keyboard" json:"keyboard"`
+	Isofilename   string            `yaml:"isofilename" json:"isofilename"`
+	Cli           []string          `yaml:"cli" json:"cli"`
+	NotVmPgCreate bool              `yaml:"notvmpgcreate" json:"notvmpgcreate"`
+	// Retry overrides DefaultRetryPolicy for every phase of this host's job.
+	Retry *RetryPolicy `yaml:"retry" json:"retry"`
+}
+
+type VmParameter struct {
+	Cpu struct {
+		Core          int32 `yaml:"core" json:"core"`
+		CorePerSocket int32 `yaml:"coreperscket" json:"coreperscket"`
+	} `yaml:"cpu" json:"cpu"`
+	Memory struct {
+		MemoryGB int64 `yaml:"memoryGB" json:"memoryGB"`
+	} `yaml:"memory" json:"memory"`
+	ControllerType string         `yaml:"controllertype" json:"controllertype"`
+	Networks       []NicParameter `yaml:"networks" json:"networks"`
+	Storages       []struct {
+		Datastore  string `yaml:"datastore" json:"datastore"`
+		CapacityGB int64  `yaml:"capacityGB" json:"capacityGB"`
+	} `yaml:"storages" json:"storages"`
+	BootOption struct {
+		Firmware   string `yaml:"firmware" json:"firmware"`
+		SecureBoot bool   `yaml:"secureboot" json:"secureboot"`
+	} `yaml:"bootoption" json:"bootoption"`
+	Source SourceParameter `yaml:"source" json:"source"`
+}
+
+// NicParameter describes a single virtual NIC: which network/port group to
+// attach to, the adapter type (vmxnet3, e1000, e1000e, pcnet32 or sriov), and
+// optional per-NIC overrides. Upt only applies to vmxnet3 adapters; it has no
+// effect on other adapter types and does not configure SR-IOV/DirectPath I/O
+// passthrough for "sriov" NICs, which still requires a physical function to
+// be wired up out of band.
+type NicParameter struct {
+	Network    string `yaml:"network" json:"network"`
+	Type       string `yaml:"type" json:"type"`
+	Label      string `yaml:"label" json:"label"`
+	Macaddress string `yaml:"macaddress" json:"macaddress"`
+	Upt        bool   `yaml:"upt" json:"upt"`
+}
+
+// SourceParameter selects how a VM is provisioned: a fresh kickstart install
+// ("install", the default), a clone of an existing template/VM ("clone"), or
+// an import of an OVF/OVA appliance ("ovf" or "ova", interchangeably; the
+// file extension on Template decides the package format).
+type SourceParameter struct {
+	Mode string `yaml:"mode" json:"mode"`
+	// Template is the clone source VM/snapshot name for "clone" mode, or
+	// the .ovf/.ova path or http(s) URL to import for "ovf"/"ova" mode.
+	Template    string `yaml:"template" json:"template"`
+	LinkedClone bool   `yaml:"linked_clone" json:"linked_clone"`
+	Snapshot    string `yaml:"snapshot" json:"snapshot"`
+}
@@ -0,0 +1,202 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"kickstart-client/kickstart"
+)
+
+// HostSpec describes one host to provision: its identity, the vCenter
+// environment and VM shape to provision it with, and whether its boot NIC
+// needs a fresh MAC address once it moves off the bootstrap port group.
+type HostSpec struct {
+	Hostname  string
+	IP        net.IP
+	Config    ESXiConfig
+	ChangeMac bool
+}
+
+// DestroySpec identifies a previously provisioned host to tear down.
+type DestroySpec struct {
+	Hostname string
+	Config   ESXiConfig
+}
+
+// Status is the lifecycle state of a host's provisioning job.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Result is the last known outcome of a host's provisioning job.
+type Result struct {
+	Hostname string `json:"hostname"`
+	Status   Status `json:"status"`
+	Err      string `json:"error,omitempty"`
+}
+
+// ErrUnknownHost is returned by Status when no job has ever run for the
+// requested hostname.
+var ErrUnknownHost = errors.New("provisioner: no job found for this host")
+
+// Driver runs the provisioning, status-reporting and teardown of ESXi hosts
+// against a specific backend. VCenterDriver is the only implementation
+// today, but callers (the CLI and the serve subcommand's HTTP API) only
+// depend on this interface, so another hypervisor or cloud backend could be
+// added without touching either of them.
+type Driver interface {
+	// Provision runs spec's pipeline to completion, honoring ctx
+	// cancellation, and blocks until the host is ready or the job fails.
+	// Callers that want several hosts in flight at once run Provision in
+	// their own goroutine per host, same as Status and Cancel expect.
+	Provision(ctx context.Context, spec HostSpec) (*Result, error)
+	// Status returns the most recently observed result for hostname.
+	Status(hostname string) (*Result, error)
+	// Cancel stops an in-flight Provision call for hostname, if one is
+	// running. It reports whether a running job was found.
+	Cancel(hostname string) bool
+	// Destroy tears down a previously provisioned host and removes any
+	// kickstart registration for it.
+	Destroy(ctx context.Context, spec DestroySpec) error
+}
+
+// jobState tracks one host's most recent result and, while it is running,
+// the cancel func for its job context.
+type jobState struct {
+	result *Result
+	cancel context.CancelFunc
+}
+
+// VCenterDriver is the Driver implementation backing the previous
+// fatal-on-error vmCreateHandler: it runs the CreateVM -> PowerOn ->
+// WaitForIP -> SwitchPortGroup -> NotifyKickstart pipeline per host via job,
+// and keeps enough per-host state to answer Status and Cancel concurrently.
+type VCenterDriver struct {
+	Logger *zap.Logger
+
+	mu   sync.Mutex
+	jobs map[string]*jobState
+
+	kickstartMu      sync.Mutex
+	kickstartClients map[string]*kickstart.Client
+}
+
+// NewVCenterDriver builds a VCenterDriver that logs with logger.
+func NewVCenterDriver(logger *zap.Logger) *VCenterDriver {
+	return &VCenterDriver{
+		Logger:           logger,
+		jobs:             make(map[string]*jobState),
+		kickstartClients: make(map[string]*kickstart.Client),
+	}
+}
+
+func (d *VCenterDriver) setState(hostname string, st *jobState) {
+	d.mu.Lock()
+	d.jobs[hostname] = st
+	d.mu.Unlock()
+}
+
+// kickstartClient returns the Client for cfg, building and caching a new one
+// on first use so replicas sharing the same kickstart server also share its
+// GuestID/Versions cache.
+func (d *VCenterDriver) kickstartClient(cfg kickstart.Config) (*kickstart.Client, error) {
+	d.kickstartMu.Lock()
+	defer d.kickstartMu.Unlock()
+
+	if c, ok := d.kickstartClients[cfg.BaseURL]; ok {
+		return c, nil
+	}
+	c, err := kickstart.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d.kickstartClients[cfg.BaseURL] = c
+	return c, nil
+}
+
+func (d *VCenterDriver) Provision(ctx context.Context, spec HostSpec) (*Result, error) {
+	kickstartClient, err := d.kickstartClient(spec.Config.Environment.KickstartServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kickstart client: %s", err)
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	d.setState(spec.Hostname, &jobState{
+		result: &Result{Hostname: spec.Hostname, Status: StatusRunning},
+		cancel: cancel,
+	})
+
+	err = newJob(spec.Hostname, spec.IP, spec.Config, spec.ChangeMac, kickstartClient, d.Logger).run(jobCtx)
+	cancel()
+
+	result := &Result{Hostname: spec.Hostname, Status: StatusSucceeded}
+	if err != nil {
+		result.Err = err.Error()
+		if errors.Is(jobCtx.Err(), context.Canceled) && ctx.Err() == nil {
+			result.Status = StatusCancelled
+		} else {
+			result.Status = StatusFailed
+		}
+	}
+	d.setState(spec.Hostname, &jobState{result: result})
+
+	return result, err
+}
+
+func (d *VCenterDriver) Status(hostname string) (*Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, ok := d.jobs[hostname]
+	if !ok {
+		return nil, ErrUnknownHost
+	}
+	result := *st.result
+	return &result, nil
+}
+
+func (d *VCenterDriver) Cancel(hostname string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, ok := d.jobs[hostname]
+	if !ok || st.cancel == nil {
+		return false
+	}
+	st.cancel()
+	return true
+}
+
+// Destroy connects to vCenter directly, rather than going through job,
+// since tearing down an already-provisioned host isn't part of the
+// provisioning pipeline and has no retry/rollback semantics of its own.
+func (d *VCenterDriver) Destroy(ctx context.Context, spec DestroySpec) error {
+	_, finder, err := connectVCenter(ctx, spec.Config.Environment.Vcenter)
+	if err != nil {
+		return err
+	}
+
+	vm, err := finder.VirtualMachine(ctx, spec.Hostname)
+	if err != nil {
+		return fmt.Errorf("could not find %q: %s", spec.Hostname, err)
+	}
+
+	task, err := vm.Destroy(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start destroy task for %q: %s", spec.Hostname, err)
+	}
+	if _, err := task.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to destroy %q: %s", spec.Hostname, err)
+	}
+	return nil
+}
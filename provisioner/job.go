@@ -0,0 +1,449 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.uber.org/zap"
+
+	"kickstart-client/kickstart"
+)
+
+// Phase identifies one step of a job's per-host provisioning pipeline.
+type Phase string
+
+const (
+	PhaseCreateVM        Phase = "CreateVM"
+	PhasePowerOn         Phase = "PowerOn"
+	PhaseWaitForIP       Phase = "WaitForIP"
+	PhaseSwitchPortGroup Phase = "SwitchPortGroup"
+	PhaseNotifyKickstart Phase = "NotifyKickstart"
+)
+
+// ErrAlreadyExists is returned by the CreateVM phase when a VM with the
+// target hostname is already present in vCenter. Run treats it as a
+// successful no-op rather than a failure needing rollback.
+var ErrAlreadyExists = errors.New("provisioner: a VM with this hostname already exists")
+
+// RetryPolicy controls how many times a phase is retried, and how long to
+// wait between attempts, before it is treated as a failure.
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"max_attempts" json:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay" json:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay" json:"max_delay"`
+}
+
+// DefaultRetryPolicy is applied to every job unless EsxiInfo.Retry overrides
+// it.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// job drives a single host through the CreateVM -> PowerOn -> WaitForIP ->
+// SwitchPortGroup -> NotifyKickstart pipeline, retrying transient phase
+// failures and rolling back any partially created VM and kickstart
+// registration if a phase ultimately fails. It backs VCenterDriver's
+// Provision method.
+type job struct {
+	hostname  string
+	ip        net.IP
+	config    ESXiConfig
+	changeMac bool
+	retry     RetryPolicy
+	logger    *zap.Logger
+
+	si                  *govmomi.Client
+	finder              *find.Finder
+	vm                  *object.VirtualMachine
+	bootNet             types.BaseVirtualEthernetCard
+	mode                string
+	kickstart           *kickstart.Client
+	requestBody         kickstart.RequestBody
+	kickstartRegistered bool
+}
+
+// newJob builds a job for a single host, ready to run. kickstartClient is
+// only used when the host's source mode is "install"; it may be nil for
+// "clone" and "ovf"/"ova" hosts. retry is config.EsxiInfo.Retry if set,
+// otherwise DefaultRetryPolicy.
+func newJob(hostname string, ip net.IP, config ESXiConfig, changeMac bool, kickstartClient *kickstart.Client, logger *zap.Logger) *job {
+	retry := DefaultRetryPolicy
+	if config.EsxiInfo.Retry != nil {
+		retry = *config.EsxiInfo.Retry
+	}
+	return &job{
+		hostname:  hostname,
+		ip:        ip,
+		config:    config,
+		changeMac: changeMac,
+		retry:     retry,
+		kickstart: kickstartClient,
+		logger:    logger.With(zap.String("hostname", hostname)),
+	}
+}
+
+// run executes every phase in order, honoring ctx cancellation at each wait
+// or retry backoff. If a phase fails after exhausting its retries, run rolls
+// back any partially created VM and kickstart registration before returning
+// the error.
+func (j *job) run(ctx context.Context) error {
+	phases := []struct {
+		name Phase
+		fn   func(context.Context) error
+	}{
+		{PhaseCreateVM, j.runCreateVM},
+		{PhasePowerOn, j.runPowerOn},
+		{PhaseWaitForIP, j.runWaitForIP},
+		{PhaseSwitchPortGroup, j.runSwitchPortGroup},
+		{PhaseNotifyKickstart, j.runNotifyKickstart},
+	}
+
+	for _, p := range phases {
+		j.logger.Info("starting phase", zap.String("phase", string(p.name)))
+		if err := j.withRetry(ctx, p.name, p.fn); err != nil {
+			if errors.Is(err, ErrAlreadyExists) {
+				j.logger.Info("VM already exists, skipping host")
+				return nil
+			}
+			j.logger.Error("phase failed, rolling back", zap.String("phase", string(p.name)), zap.Error(err))
+			j.rollback()
+			return fmt.Errorf("%s: %s phase: %w", j.hostname, p.name, err)
+		}
+		j.logger.Info("phase completed", zap.String("phase", string(p.name)))
+	}
+	return nil
+}
+
+// withRetry runs fn, retrying on failure up to j.retry.MaxAttempts with an
+// exponential backoff between attempts, capped at MaxDelay. It returns
+// early, without retrying, on ctx cancellation or ErrAlreadyExists.
+func (j *job) withRetry(ctx context.Context, phase Phase, fn func(context.Context) error) error {
+	var err error
+	for attempt := 1; attempt <= j.retry.MaxAttempts; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrAlreadyExists) {
+			return err
+		}
+		if attempt == j.retry.MaxAttempts {
+			break
+		}
+
+		delay := j.retry.BaseDelay << (attempt - 1)
+		if delay > j.retry.MaxDelay {
+			delay = j.retry.MaxDelay
+		}
+		j.logger.Warn("phase attempt failed, retrying",
+			zap.String("phase", string(phase)), zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// runCreateVM connects to vCenter, checks the host isn't already present,
+// provisions the VM via createVM or cloneVM depending on the configured
+// source mode, locates its boot NIC, and (for a fresh install) registers the
+// host with the kickstart server ahead of first boot.
+func (j *job) runCreateVM(ctx context.Context) error {
+	environment := j.config.Environment
+	vmParam := j.config.VmParameter
+	esxiInfo := j.config.EsxiInfo
+
+	// A retry of this phase after j.vm is already set means a previous
+	// attempt created the VM but failed later (e.g. while locating the boot
+	// NIC); redoing the existing-VM check here would see our own VM and
+	// mistake it for ErrAlreadyExists. Just pick up where we left off.
+	if j.vm == nil {
+		si, finder, err := connectVCenter(ctx, environment.Vcenter)
+		if err != nil {
+			return err
+		}
+		j.si = si
+		j.finder = finder
+
+		if _, err := j.finder.VirtualMachine(ctx, j.hostname); err == nil {
+			return ErrAlreadyExists
+		} else if _, ok := err.(*find.NotFoundError); !ok {
+			return err
+		}
+
+		vcInfo := environment.Vcenter
+		dc, err := j.finder.Datacenter(ctx, vcInfo.Datacenter)
+		if err != nil {
+			return err
+		}
+		folders, err := dc.Folders(ctx)
+		if err != nil {
+			return err
+		}
+		getvmfolder := filepath.Join(folders.VmFolder.InventoryPath, vcInfo.Folder)
+		vmfolder := filepath.ToSlash(getvmfolder)
+
+		targetFolder, err := j.finder.Folder(ctx, vmfolder)
+		if err != nil {
+			return err
+		}
+
+		targetResourcePool, err := j.finder.ResourcePool(ctx, vcInfo.ResourcePool)
+		if err != nil {
+			return err
+		}
+
+		mode := vmParam.Source.Mode
+		if mode == "" {
+			mode = "install"
+		}
+		j.mode = mode
+
+		var newVM *object.VirtualMachine
+		switch mode {
+		case "install":
+			guestId, guestErr := j.kickstart.GuestID(ctx, esxiInfo.Isofilename)
+			if guestErr != nil {
+				return guestErr
+			}
+			newVM, err = createVM(ctx, j.si, j.finder, targetFolder, targetResourcePool, j.hostname, environment, vmParam, guestId)
+		case "clone":
+			newVM, err = cloneVM(ctx, j.si, j.finder, targetFolder, targetResourcePool, j.hostname, vmParam.Source)
+		case "ovf", "ova":
+			newVM, err = deployOVF(ctx, j.si, j.finder, targetFolder, targetResourcePool, j.hostname, vmParam, j.logger)
+		default:
+			return fmt.Errorf("unsupported source mode %q", mode)
+		}
+		if err != nil {
+			return err
+		}
+		j.vm = newVM
+	}
+
+	var newVMProps mo.VirtualMachine
+	if err := j.vm.Properties(ctx, j.vm.Reference(), []string{"config.hardware.device"}, &newVMProps); err != nil {
+		return fmt.Errorf("failed to get VM properties for boot mac address: %s", err)
+	}
+
+	var bootNet types.BaseVirtualEthernetCard
+	for _, device := range newVMProps.Config.Hardware.Device {
+		if dev, ok := device.(types.BaseVirtualEthernetCard); ok {
+			if devinfo, ok := dev.GetVirtualEthernetCard().DeviceInfo.(*types.Description); ok && devinfo.Label == "Network adapter 1" {
+				bootNet = dev
+				break
+			}
+		}
+	}
+	if bootNet == nil {
+		return fmt.Errorf("could not find boot network adapter on %s", j.hostname)
+	}
+	j.bootNet = bootNet
+
+	if j.mode == "install" {
+		j.requestBody = kickstart.RequestBody{
+			Macaddress:  bootNet.GetVirtualEthernetCard().MacAddress,
+			Password:    esxiInfo.Password,
+			Hostname:    j.hostname,
+			IP:          j.ip.String(),
+			Netmask:     esxiInfo.Netmask,
+			Gateway:     esxiInfo.Gateway,
+			Nameserver:  esxiInfo.Nameserver,
+			Vlanid:      esxiInfo.Vlanid,
+			Keyboard:    esxiInfo.Keyboard,
+			Isofilename: esxiInfo.Isofilename,
+			Cli:         esxiInfo.Cli,
+		}
+		if err := j.kickstart.RegisterHost(ctx, j.requestBody); err != nil {
+			return err
+		}
+		j.kickstartRegistered = true
+	}
+
+	return nil
+}
+
+func (j *job) runPowerOn(ctx context.Context) error {
+	task, err := j.vm.PowerOn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start VM: %s", err)
+	}
+	if _, err := task.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to power on VM: %s", err)
+	}
+	return nil
+}
+
+func (j *job) runWaitForIP(ctx context.Context) error {
+	return waitForIP(ctx, j.vm, j.ip.String(), j.hostname, j.logger)
+}
+
+// runSwitchPortGroup moves the boot NIC from the bootstrap port group onto
+// the VM's real network. When j.changeMac is set it does so by removing and
+// re-adding the adapter with a fresh MAC, since vmk0 and vmnic0 would
+// otherwise permanently share the installer's boot address.
+func (j *job) runSwitchPortGroup(ctx context.Context) error {
+	vmParam := j.config.VmParameter
+	newNet, err := j.finder.Network(ctx, vmParam.Networks[0].Network)
+	if err != nil {
+		return fmt.Errorf("could not find target network %q: %s", vmParam.Networks[0].Network, err)
+	}
+	backing, err := newNet.EthernetCardBackingInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get network backing info for %q: %s", vmParam.Networks[0].Network, err)
+	}
+	j.bootNet.GetVirtualEthernetCard().Backing = backing
+
+	if j.changeMac {
+		return j.switchPortGroupWithMacChange(ctx)
+	}
+	return j.switchPortGroupInPlace(ctx)
+}
+
+func (j *job) switchPortGroupInPlace(ctx context.Context) error {
+	configSpec := &types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+				Device:    j.bootNet.(types.BaseVirtualDevice),
+			},
+		},
+	}
+	task, err := j.vm.Reconfigure(ctx, *configSpec)
+	if err != nil {
+		return fmt.Errorf("failed to change portgroup for boot network: %s", err)
+	}
+	if _, err := task.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to change portgroup for boot network: %s", err)
+	}
+	return nil
+}
+
+func (j *job) switchPortGroupWithMacChange(ctx context.Context) error {
+	j.logger.Info("shutting down for mac address change")
+	if err := j.vm.ShutdownGuest(ctx); err != nil {
+		return fmt.Errorf("failed to shut down VM: %s", err)
+	}
+	if err := waitForPowerState(ctx, j.si, j.vm, types.VirtualMachinePowerStatePoweredOff); err != nil {
+		return err
+	}
+
+	j.logger.Info("removing boot network adapter")
+	removeSpec := &types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationRemove,
+				Device:    j.bootNet.(types.BaseVirtualDevice),
+			},
+		},
+	}
+	task, err := j.vm.Reconfigure(ctx, *removeSpec)
+	if err != nil {
+		return fmt.Errorf("failed to remove boot network adapter: %s", err)
+	}
+	if _, err := task.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to remove boot network adapter: %s", err)
+	}
+
+	j.logger.Info("adding replacement network adapter")
+	j.bootNet.GetVirtualEthernetCard().MacAddress = ""
+	addSpec := &types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationAdd,
+				Device:    j.bootNet.(types.BaseVirtualDevice),
+			},
+		},
+	}
+	task, err = j.vm.Reconfigure(ctx, *addSpec)
+	if err != nil {
+		return fmt.Errorf("failed to add replacement network adapter: %s", err)
+	}
+	if _, err := task.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to add replacement network adapter: %s", err)
+	}
+
+	j.logger.Info("powering on after mac address change")
+	powerOnTask, err := j.vm.PowerOn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to power on VM: %s", err)
+	}
+	if _, err := powerOnTask.WaitForResult(ctx, nil); err != nil {
+		return fmt.Errorf("failed to power on VM: %s", err)
+	}
+
+	return waitForIP(ctx, j.vm, j.ip.String(), j.hostname, j.logger)
+}
+
+// waitForPowerState polls vm's power state every 5 seconds until it matches
+// want, honoring ctx cancellation.
+func waitForPowerState(ctx context.Context, si *govmomi.Client, vm *object.VirtualMachine, want types.VirtualMachinePowerState) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var props mo.VirtualMachine
+		if err := si.RetrieveOne(ctx, vm.Reference(), []string{"summary.runtime.powerState"}, &props); err != nil {
+			return fmt.Errorf("failed to retrieve power state: %s", err)
+		}
+		if props.Summary.Runtime.PowerState == want {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *job) runNotifyKickstart(ctx context.Context) error {
+	if j.mode != "install" {
+		return nil
+	}
+	if err := j.kickstart.DeregisterHost(ctx, j.requestBody.Macaddress); err != nil {
+		return err
+	}
+	j.kickstartRegistered = false
+	return nil
+}
+
+// rollback cleans up after a phase failure: it destroys the VM if one was
+// created and removes any kickstart registration that was made for it. It
+// runs against a fresh, short-lived context rather than the job's own ctx,
+// so cleanup can still complete even if that ctx was already cancelled.
+func (j *job) rollback() {
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if j.vm != nil {
+		j.logger.Warn("rolling back: destroying partially provisioned VM")
+		task, err := j.vm.Destroy(rollbackCtx)
+		if err != nil {
+			j.logger.Error("rollback: failed to start VM destroy task", zap.Error(err))
+		} else if _, err := task.WaitForResult(rollbackCtx, nil); err != nil {
+			j.logger.Error("rollback: failed to destroy VM", zap.Error(err))
+		}
+	}
+
+	if j.kickstartRegistered {
+		j.logger.Warn("rolling back: removing kickstart registration")
+		if err := j.kickstart.DeregisterHost(rollbackCtx, j.requestBody.Macaddress); err != nil {
+			j.logger.Error("rollback: failed to remove kickstart registration", zap.Error(err))
+		}
+	}
+}
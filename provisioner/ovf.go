@@ -0,0 +1,315 @@
+package provisioner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/progress"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.uber.org/zap"
+)
+
+// deployOVF provisions a new VM by importing an OVF/OVA package from a local
+// file or an http(s) URL, instead of installing ESXi fresh or cloning a
+// template. It follows the same CreateImportSpec -> ResourcePool.ImportVApp
+// -> nfc.Lease.Upload -> Lease.Complete sequence as govc's `import.ovf` and
+// packer's vsphere-iso builder, mapping the OVF's declared networks onto
+// vmParam.Networks by position and using vmParam.Storages[0] as the target
+// datastore.
+//
+// source.Template naming a vCenter Content Library item (rather than a
+// path or URL) isn't supported yet; CreateImportSpec still needs an OVF
+// descriptor read from disk, so that path would first have to resolve the
+// item through the content library API and export its descriptor.
+func deployOVF(ctx context.Context, si *govmomi.Client, finder *find.Finder, targetFolder *object.Folder, targetResourcePool *object.ResourcePool, hostname string, vmParam VmParameter, logger *zap.Logger) (*object.VirtualMachine, error) {
+	source := vmParam.Source
+	if source.Template == "" {
+		return nil, fmt.Errorf("ovf/ova source requires a template path or URL")
+	}
+	if len(vmParam.Storages) == 0 {
+		return nil, fmt.Errorf("ovf/ova source requires at least one entry in storages")
+	}
+	if len(vmParam.Networks) == 0 {
+		return nil, fmt.Errorf("ovf/ova source requires at least one entry in networks")
+	}
+	ext := strings.ToLower(filepath.Ext(source.Template))
+	if !isHTTPLocation(source.Template) && ext != ".ovf" && ext != ".ova" {
+		return nil, fmt.Errorf("template %q looks like a content library item name, which isn't supported yet; use a local path or http(s) URL to an .ovf/.ova", source.Template)
+	}
+
+	pkg, err := newOvfPackage(source.Template, si)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := pkg.descriptor(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ovf descriptor from %q: %s", source.Template, err)
+	}
+	envelope, err := ovf.Unmarshal(bytes.NewReader(descriptor))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ovf descriptor from %q: %s", source.Template, err)
+	}
+
+	nmap, err := ovfNetworkMapping(ctx, finder, envelope, vmParam.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	datastore, err := finder.Datastore(ctx, vmParam.Storages[0].Datastore)
+	if err != nil {
+		return nil, fmt.Errorf("could not find target datastore %q: %s", vmParam.Storages[0].Datastore, err)
+	}
+
+	cisp := types.OvfCreateImportSpecParams{
+		EntityName:     hostname,
+		NetworkMapping: nmap,
+		OvfManagerCommonParams: types.OvfManagerCommonParams{
+			Locale: "US",
+		},
+	}
+
+	m := ovf.NewManager(si.Client)
+	spec, err := m.CreateImportSpec(ctx, string(descriptor), targetResourcePool, datastore, cisp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import spec for %q: %s", source.Template, err)
+	}
+	if spec.Error != nil {
+		return nil, fmt.Errorf("failed to create import spec for %q: %s", source.Template, spec.Error[0].LocalizedMessage)
+	}
+	for _, w := range spec.Warning {
+		logger.Warn("ovf import warning", zap.String("detail", w.LocalizedMessage))
+	}
+
+	lease, err := targetResourcePool.ImportVApp(ctx, spec.ImportSpec, targetFolder, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start import for %q: %s", source.Template, err)
+	}
+
+	info, err := lease.Wait(ctx, spec.FileItem)
+	if err != nil {
+		return nil, fmt.Errorf("ovf lease for %q failed: %s", source.Template, err)
+	}
+
+	updater := lease.StartUpdater(ctx, info)
+	defer updater.Done()
+
+	for _, item := range info.Items {
+		if err := uploadOvfItem(ctx, pkg, lease, item, logger); err != nil {
+			return nil, fmt.Errorf("failed to upload %q: %s", item.Path, err)
+		}
+	}
+
+	if err := lease.Complete(ctx); err != nil {
+		return nil, fmt.Errorf("failed to complete import for %q: %s", source.Template, err)
+	}
+
+	return object.NewVirtualMachine(si.Client, info.Entity), nil
+}
+
+// uploadOvfItem streams one disk (or other member file) named by item from
+// pkg up to vCenter via lease, logging percent-complete as it goes.
+func uploadOvfItem(ctx context.Context, pkg ovfPackage, lease *nfc.Lease, item nfc.FileItem, logger *zap.Logger) error {
+	r, size, err := pkg.open(ctx, item.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	opts := soap.Upload{
+		ContentLength: size,
+		Progress:      ovfUploadProgress{logger: logger, file: item.Path},
+	}
+	return lease.Upload(ctx, item, r, opts)
+}
+
+// ovfUploadProgress logs upload percentage as govmomi reports it, standing
+// in for the progress bar govc prints to a terminal.
+type ovfUploadProgress struct {
+	logger *zap.Logger
+	file   string
+}
+
+func (p ovfUploadProgress) Sink() chan<- progress.Report {
+	ch := make(chan progress.Report)
+	go func() {
+		for report := range ch {
+			if err := report.Error(); err != nil {
+				p.logger.Error("ovf upload failed", zap.String("file", p.file), zap.Error(err))
+				continue
+			}
+			p.logger.Info("uploading ovf disk", zap.String("file", p.file), zap.Float32("percent", report.Percentage()))
+		}
+	}()
+	return ch
+}
+
+// ovfNetworkMapping pairs each network the OVF declares, in the order the
+// descriptor lists them, with the corresponding entry of vmParam.Networks.
+func ovfNetworkMapping(ctx context.Context, finder *find.Finder, envelope *ovf.Envelope, networks []NicParameter) ([]types.OvfNetworkMapping, error) {
+	if envelope.Network == nil {
+		return nil, nil
+	}
+
+	var nmap []types.OvfNetworkMapping
+	for i, n := range envelope.Network.Networks {
+		if i >= len(networks) {
+			break
+		}
+		net, err := finder.Network(ctx, networks[i].Network)
+		if err != nil {
+			return nil, fmt.Errorf("could not find target network %q for ovf network %q: %s", networks[i].Network, n.Name, err)
+		}
+		nmap = append(nmap, types.OvfNetworkMapping{Name: n.Name, Network: net.Reference()})
+	}
+	return nmap, nil
+}
+
+// ovfPackage reads the OVF descriptor and member files (disks, manifest,
+// etc.) of a package, regardless of whether it's a loose .ovf with sibling
+// files or a single .ova tar archive, and regardless of whether it lives on
+// local disk or behind an http(s) URL.
+type ovfPackage interface {
+	descriptor(ctx context.Context) ([]byte, error)
+	open(ctx context.Context, name string) (io.ReadCloser, int64, error)
+}
+
+// newOvfPackage picks the ovfPackage implementation for location based on
+// its extension: ".ova" is a tar archive, anything else is treated as a
+// loose ".ovf" descriptor with sibling member files.
+func newOvfPackage(location string, si *govmomi.Client) (ovfPackage, error) {
+	opener := ovfOpener{client: si}
+	if strings.EqualFold(filepath.Ext(location), ".ova") {
+		return &tarOvfPackage{path: location, opener: opener}, nil
+	}
+	return &fileOvfPackage{path: location, opener: opener}, nil
+}
+
+// ovfOpener opens a member of an OVF/OVA package from local disk or, for an
+// http(s) location, over si's existing HTTP client.
+type ovfOpener struct {
+	client *govmomi.Client
+}
+
+func (o ovfOpener) open(ctx context.Context, location string) (io.ReadCloser, int64, error) {
+	if !isHTTPLocation(location) {
+		f, err := os.Open(filepath.Clean(location))
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, 0, err
+	}
+	return o.client.Client.Download(ctx, u, &soap.DefaultDownload)
+}
+
+func isHTTPLocation(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// fileOvfPackage is a loose ".ovf" descriptor with its disks as sibling
+// files (or sibling URLs) alongside it.
+type fileOvfPackage struct {
+	path   string
+	opener ovfOpener
+}
+
+func (f *fileOvfPackage) descriptor(ctx context.Context) ([]byte, error) {
+	r, _, err := f.opener.open(ctx, f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (f *fileOvfPackage) open(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	if name == path.Base(f.path) {
+		return f.opener.open(ctx, f.path)
+	}
+	dir := f.path
+	if i := strings.LastIndex(f.path, "/"); i != -1 {
+		dir = f.path[:i+1]
+	} else {
+		dir = ""
+	}
+	return f.opener.open(ctx, dir+name)
+}
+
+// tarOvfPackage is a ".ova": a single tar archive containing the descriptor
+// and all of its member files.
+type tarOvfPackage struct {
+	path   string
+	opener ovfOpener
+}
+
+func (t *tarOvfPackage) descriptor(ctx context.Context) ([]byte, error) {
+	r, _, err := t.find(ctx, "*.ovf")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (t *tarOvfPackage) open(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	return t.find(ctx, name)
+}
+
+func (t *tarOvfPackage) find(ctx context.Context, pattern string) (io.ReadCloser, int64, error) {
+	f, _, err := t.opener.open(ctx, t.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			f.Close()
+			return nil, 0, fmt.Errorf("%q not found in %s", pattern, t.path)
+		}
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		if matched, _ := path.Match(pattern, path.Base(h.Name)); matched {
+			return tarOvfEntry{Reader: tr, archive: f}, h.Size, nil
+		}
+	}
+}
+
+// tarOvfEntry adapts a tar.Reader positioned at one member onto io.ReadCloser,
+// closing the underlying archive handle once the caller is done with it.
+type tarOvfEntry struct {
+	io.Reader
+	archive io.Closer
+}
+
+func (e tarOvfEntry) Close() error {
+	return e.archive.Close()
+}
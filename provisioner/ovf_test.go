@@ -0,0 +1,39 @@
+package provisioner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+func TestOvfNetworkMappingNoOvfNetworks(t *testing.T) {
+	envelope := &ovf.Envelope{}
+
+	nmap, err := ovfNetworkMapping(context.Background(), nil, envelope, []NicParameter{{Network: "VM Network"}})
+	if err != nil {
+		t.Fatalf("ovfNetworkMapping: %s", err)
+	}
+	if nmap != nil {
+		t.Errorf("ovfNetworkMapping(no ovf networks) = %v, want nil", nmap)
+	}
+}
+
+func TestOvfNetworkMappingStopsAtShorterNetworksList(t *testing.T) {
+	envelope := &ovf.Envelope{
+		Network: &ovf.NetworkSection{
+			Networks: []ovf.Network{{Name: "bridged"}, {Name: "internal"}},
+		},
+	}
+
+	// vmParam.Networks has nothing to pair with either ovf network, so the
+	// position-based mapping must stop before it ever calls out to a
+	// Finder, rather than indexing past the end of an empty slice.
+	nmap, err := ovfNetworkMapping(context.Background(), nil, envelope, nil)
+	if err != nil {
+		t.Fatalf("ovfNetworkMapping: %s", err)
+	}
+	if nmap != nil {
+		t.Errorf("ovfNetworkMapping(networks shorter than ovf list) = %v, want nil", nmap)
+	}
+}
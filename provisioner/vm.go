@@ -0,0 +1,309 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.uber.org/zap"
+)
+
+// createVM builds a fresh ESXi VM to be installed via kickstart, as done before
+// template cloning was supported. guestId is resolved ahead of time by the
+// caller via kickstart.Client.GuestID, since that lookup talks to the
+// kickstart server rather than vCenter.
+func createVM(ctx context.Context, si *govmomi.Client, finder *find.Finder, targetFolder *object.Folder, targetResourcePool *object.ResourcePool, hostname string, environment Environment, vmParam VmParameter, guestId string) (*object.VirtualMachine, error) {
+	configSpec := &types.VirtualMachineConfigSpec{
+		Name:              hostname,
+		GuestId:           guestId,
+		NumCPUs:           vmParam.Cpu.Core,
+		NumCoresPerSocket: vmParam.Cpu.CorePerSocket,
+		NestedHVEnabled:   types.NewBool(true),
+		MemoryMB:          vmParam.Memory.MemoryGB * 1024,
+		Files: &types.VirtualMachineFileInfo{
+			VmPathName: fmt.Sprintf("[%s]", vmParam.Storages[0].Datastore),
+		},
+	}
+
+	bootoption := vmParam.BootOption
+	switch bootoption.Firmware {
+	case "efi":
+		if bootoption.SecureBoot {
+			configSpec.BootOptions = &types.VirtualMachineBootOptions{
+				EfiSecureBootEnabled: types.NewBool(true),
+			}
+		}
+		configSpec.Firmware = "efi"
+	case "bios":
+		configSpec.Firmware = "bios"
+	case "http-efi":
+		if bootoption.SecureBoot {
+			configSpec.BootOptions = &types.VirtualMachineBootOptions{
+				EfiSecureBootEnabled: types.NewBool(true),
+			}
+		}
+		configSpec.ExtraConfig = []types.BaseOptionValue{
+			&types.OptionValue{
+				Key:   "networkBootProtocol",
+				Value: "httpv4",
+			},
+		}
+		configSpec.Firmware = "efi"
+	}
+	devices := object.VirtualDeviceList{}
+	devices, scsictlKey, err := createController(devices, vmParam.ControllerType)
+	if err != nil {
+		return nil, err
+	}
+	for i, datastore := range vmParam.Storages {
+		devices = createVirtualDisk(i, devices, datastore.CapacityGB, hostname, scsictlKey, datastore.Datastore)
+	}
+	for i, nic := range vmParam.Networks {
+		networkName := nic.Network
+		if i == 0 {
+			networkName = environment.BootPortGroup
+		}
+		net, err := finder.Network(ctx, networkName)
+		if err != nil {
+			return nil, fmt.Errorf("could not find target network %q: %s", networkName, err)
+		}
+		networkBacking, err := net.EthernetCardBackingInfo(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not get network backing info for %q: %s", networkName, err)
+		}
+		devices, err = createNetwork(devices, networkBacking, nic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deviceChange, err := devices.ConfigSpec(types.VirtualDeviceConfigSpecOperationAdd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device ConfigSpec: %s", err)
+	}
+	configSpec.DeviceChange = deviceChange
+	task, err := targetFolder.CreateVM(ctx, *configSpec, targetResourcePool, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start create VM task: %s", err)
+	}
+
+	taskInfo, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM: %s", err)
+	}
+
+	return object.NewVirtualMachine(si.Client, taskInfo.Result.(types.ManagedObjectReference)), nil
+}
+
+// cloneVM provisions a new VM by cloning an existing template or golden VM,
+// instead of building one up from scratch for a kickstart install. When
+// source.LinkedClone is set, the clone is created as a linked clone against
+// source.Snapshot using createNewChildDiskBacking, which is dramatically
+// faster and cheaper on storage than a full clone.
+func cloneVM(ctx context.Context, si *govmomi.Client, finder *find.Finder, targetFolder *object.Folder, targetResourcePool *object.ResourcePool, hostname string, source SourceParameter) (*object.VirtualMachine, error) {
+	srcVM, err := finder.VirtualMachine(ctx, source.Template)
+	if err != nil {
+		return nil, fmt.Errorf("could not find source template %q: %s", source.Template, err)
+	}
+
+	cloneSpec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{
+			Folder: types.NewReference(targetFolder.Reference()),
+			Pool:   types.NewReference(targetResourcePool.Reference()),
+		},
+		PowerOn: false,
+	}
+
+	if source.Snapshot != "" {
+		snapshotRef, err := srcVM.FindSnapshot(ctx, source.Snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("could not find snapshot %q on %q: %s", source.Snapshot, source.Template, err)
+		}
+		cloneSpec.Snapshot = snapshotRef
+	}
+
+	if source.LinkedClone {
+		if cloneSpec.Snapshot == nil {
+			return nil, fmt.Errorf("linked_clone requires a snapshot for template %q", source.Template)
+		}
+		cloneSpec.Location.DiskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+	}
+
+	task, err := srcVM.Clone(ctx, targetFolder, hostname, cloneSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start clone task for %q: %s", hostname, err)
+	}
+
+	taskInfo, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone %q from %q: %s", hostname, source.Template, err)
+	}
+
+	return object.NewVirtualMachine(si.Client, taskInfo.Result.(types.ManagedObjectReference)), nil
+}
+
+func waitForIP(ctx context.Context, vm *object.VirtualMachine, targetIP string, hostname string, logger *zap.Logger) error {
+	ticker := time.NewTicker(time.Second * 60)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			var mo mo.VirtualMachine
+			err := vm.Properties(ctx, vm.Reference(), []string{"guest"}, &mo)
+			if err != nil {
+				return err
+			}
+
+			if mo.Guest.IpAddress == targetIP {
+				logger.Info("VM IP address matches expected", zap.String("ip", mo.Guest.IpAddress))
+				return nil
+			}
+			currentIP := mo.Guest.IpAddress
+			if currentIP == "" {
+				currentIP = "null"
+			}
+			logger.Info("waiting for install to report IP", zap.String("current_ip", currentIP))
+		}
+	}
+}
+
+// scsiControllerTypeAliases maps the friendly controller type names accepted
+// in VmParameter YAML to the type names understood by govmomi's
+// object.SCSIControllerTypes().
+var scsiControllerTypeAliases = map[string]string{
+	"scsi-lsi-parallel": "lsilogic",
+	"scsi-buslogic":     "buslogic",
+	"scsi-paravirtual":  "pvscsi",
+	"scsi-lsi-sas":      "lsilogic-sas",
+}
+
+// createController adds the storage controller driving the VM's disks.
+// controllerType is one of the scsiControllerTypeAliases keys, or "ide";
+// it defaults to "scsi-paravirtual" to preserve the previous hardcoded
+// ParaVirtualSCSI behavior.
+func createController(devices object.VirtualDeviceList, controllerType string) (object.VirtualDeviceList, int32, error) {
+	if controllerType == "" {
+		controllerType = "scsi-paravirtual"
+	}
+
+	if controllerType == "ide" {
+		ide, err := devices.CreateIDEController()
+		if err != nil {
+			return devices, 0, fmt.Errorf("failed to create IDE controller: %s", err)
+		}
+		devices = append(devices, ide)
+		return devices, ide.GetVirtualDevice().Key, nil
+	}
+
+	scsiType, ok := scsiControllerTypeAliases[controllerType]
+	if !ok {
+		return devices, 0, fmt.Errorf("unknown controller type %q", controllerType)
+	}
+
+	scsi, err := devices.CreateSCSIController(scsiType)
+	if err != nil {
+		return devices, 0, fmt.Errorf("failed to create %q controller: %s", controllerType, err)
+	}
+	devices = append(devices, scsi)
+	return devices, scsi.GetVirtualDevice().Key, nil
+}
+
+func createVirtualDisk(i int, devices object.VirtualDeviceList, diskSizeKB int64, vmName string, scsictlKey int32, datastore string) object.VirtualDeviceList {
+	disk := &types.VirtualDisk{
+		VirtualDevice: types.VirtualDevice{
+			Key:        devices.NewKey(),
+			UnitNumber: new(int32),
+			Backing: &types.VirtualDiskFlatVer2BackingInfo{
+				DiskMode:        string(types.VirtualDiskModePersistent),
+				ThinProvisioned: types.NewBool(true),
+			},
+		},
+		CapacityInKB: diskSizeKB * 1024 * 1024,
+	}
+	*disk.UnitNumber = int32(i)
+	if i >= 7 {
+		*disk.UnitNumber++
+	}
+	var filename string
+	if i == 0 {
+		filename = fmt.Sprintf("[%s] %s/%s.vmdk", datastore, vmName, vmName)
+	} else {
+		filename = fmt.Sprintf("[%s] %s/%s_%d.vmdk", datastore, vmName, vmName, i)
+	}
+	diskControllerKey := scsictlKey
+	disk.ControllerKey = diskControllerKey
+	disk.Backing = &types.VirtualDiskFlatVer2BackingInfo{
+		VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+			FileName: filename,
+		},
+		DiskMode:        string(types.VirtualDiskModePersistent),
+		ThinProvisioned: types.NewBool(true),
+	}
+	devices = append(devices, disk)
+	return devices
+}
+
+// createNetwork adds a NIC of the type requested by nic.Type (defaulting to
+// "vmxnet3"), applying the per-NIC label, explicit MAC address and, for
+// vmxnet3 adapters, the UPT (Universal Pass-Through) performance hint from
+// the YAML. It does not configure SR-IOV/DirectPath I/O passthrough; an
+// "sriov" nic.Type only selects the adapter model.
+func createNetwork(devices object.VirtualDeviceList, networkBacking types.BaseVirtualDeviceBackingInfo, nic NicParameter) (object.VirtualDeviceList, error) {
+	cardType := nic.Type
+	if cardType == "" {
+		cardType = "vmxnet3"
+	}
+
+	device, err := object.EthernetCardTypes().CreateEthernetCard(cardType, networkBacking)
+	if err != nil {
+		return devices, fmt.Errorf("failed to create %q network adapter for %q: %s", cardType, nic.Network, err)
+	}
+
+	card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+	if nic.Macaddress != "" {
+		card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+		card.MacAddress = nic.Macaddress
+	}
+	if nic.Label != "" {
+		card.DeviceInfo = &types.Description{Label: nic.Label}
+	}
+	if nic.Upt {
+		card.UptCompatibilityEnabled = types.NewBool(true)
+	}
+
+	devices = append(devices, device)
+	return devices, nil
+}
+
+// connectVCenter establishes a govmomi session and a Finder scoped to
+// vcInfo.Datacenter. It is shared by job's CreateVM phase and by Destroy,
+// which both need a connection but otherwise touch disjoint parts of the
+// inventory.
+func connectVCenter(ctx context.Context, vcInfo VcenterInfo) (*govmomi.Client, *find.Finder, error) {
+	vcUrl, err := url.Parse(fmt.Sprintf("https://%s:%s@%s/sdk", vcInfo.Username, vcInfo.Password, vcInfo.Hostname))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse vCenter URL: %s", err)
+	}
+	si, err := govmomi.NewClient(ctx, vcUrl, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to vCenter: %s", err)
+	}
+
+	finder := find.NewFinder(si.Client, true)
+	dc, err := finder.Datacenter(ctx, vcInfo.Datacenter)
+	if err != nil {
+		return nil, nil, err
+	}
+	finder.SetDatacenter(dc)
+
+	return si, finder, nil
+}
@@ -0,0 +1,31 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+)
+
+func TestCreateControllerKnownTypes(t *testing.T) {
+	for _, controllerType := range []string{"", "ide", "scsi-lsi-parallel", "scsi-buslogic", "scsi-paravirtual", "scsi-lsi-sas"} {
+		t.Run(controllerType, func(t *testing.T) {
+			devices, key, err := createController(object.VirtualDeviceList{}, controllerType)
+			if err != nil {
+				t.Fatalf("createController(%q): %s", controllerType, err)
+			}
+			if len(devices) != 1 {
+				t.Fatalf("createController(%q) added %d devices, want 1", controllerType, len(devices))
+			}
+			if key == 0 {
+				t.Errorf("createController(%q) returned key 0, want a non-zero device key", controllerType)
+			}
+		})
+	}
+}
+
+func TestCreateControllerUnknownType(t *testing.T) {
+	_, _, err := createController(object.VirtualDeviceList{}, "scsi-made-up")
+	if err == nil {
+		t.Fatal("createController(unknown type) = nil error, want error")
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import "sync"
+
+// logHub fans out a batch's log lines to any number of SSE subscribers. It
+// implements io.Writer so it can back a zapcore.Core directly: each Write
+// call is one already-formatted log line.
+type logHub struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+func newLogHub() *logHub {
+	return &logHub{subs: make(map[chan string]struct{})}
+}
+
+func (h *logHub) Write(p []byte) (int, error) {
+	line := string(p)
+
+	h.mu.Lock()
+	for ch := range h.subs {
+		select {
+		case ch <- line:
+		default:
+			// Slow subscriber; drop the line rather than block provisioning.
+		}
+	}
+	h.mu.Unlock()
+
+	return len(p), nil
+}
+
+// subscribe registers a new listener and returns a channel that receives
+// every log line written after this call.
+func (h *logHub) subscribe() chan string {
+	ch := make(chan string, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *logHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+	close(ch)
+}
@@ -0,0 +1,284 @@
+// Package server exposes provisioner.Driver over HTTP+JSON so orchestrators
+// (Terraform providers, CI systems, Rancher-style tooling) can submit and
+// track host-provisioning batches programmatically, instead of invoking the
+// kickstart-client binary once per run. It is started by the "serve"
+// subcommand.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"kickstart-client/provisioner"
+)
+
+// batchRequest is the POST /batches body: an ESXiConfig plus the one CLI
+// flag (-changemac) that isn't part of the YAML/JSON config shape.
+type batchRequest struct {
+	provisioner.ESXiConfig
+	ChangeMac bool `json:"changemac"`
+}
+
+// batch tracks one submitted group of hosts: the driver running their jobs,
+// the hostnames in submission order, and the log hub events stream from.
+type batch struct {
+	id       string
+	hostname []string
+	driver   *provisioner.VCenterDriver
+	hub      *logHub
+	cancel   context.CancelFunc
+}
+
+// Server serves the batch-submission, status, cancel and log-streaming
+// endpoints.
+type Server struct {
+	logger *zap.Logger
+	token  string
+
+	mu      sync.Mutex
+	batches map[string]*batch
+}
+
+// New builds a Server that logs with logger and authenticates every request
+// with an "Authorization: Bearer" header matching token. token must not be
+// empty: the batch-create endpoint accepts vCenter credentials and drives
+// real provisioning, so this daemon must never be exposed unauthenticated.
+func New(logger *zap.Logger, token string) (*Server, error) {
+	if token == "" {
+		return nil, fmt.Errorf("server: a bearer token is required")
+	}
+	return &Server{
+		logger:  logger,
+		token:   token,
+		batches: make(map[string]*batch),
+	}, nil
+}
+
+// ListenAndServe registers the API's routes and blocks serving on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/batches", s.handleCreateBatch)
+	mux.HandleFunc("/batches/", s.handleBatchSubResource)
+	return http.ListenAndServe(addr, s.requireAuth(mux))
+}
+
+// requireAuth rejects any request whose "Authorization: Bearer" header
+// doesn't match s.token with a constant-time comparison.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "Bearer "
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, prefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, prefix)), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleCreateBatch accepts a batch of hosts to provision: it expands the
+// submitted config into per-host specs, starts one Provision goroutine per
+// host against a fresh driver, and returns immediately with the batch ID and
+// the hostnames assigned to it.
+func (s *Server) handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	specs, err := provisioner.ExpandHosts(req.ESXiConfig, req.ChangeMac)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid config: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := newBatchID()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to allocate batch id: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	hub := newLogHub()
+	logger := s.logger.With(zap.String("batch_id", id)).
+		WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			hubCore := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(hub), zap.InfoLevel)
+			return zapcore.NewTee(core, hubCore)
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	driver := provisioner.NewVCenterDriver(logger)
+
+	b := &batch{id: id, driver: driver, hub: hub, cancel: cancel}
+	for _, spec := range specs {
+		b.hostname = append(b.hostname, spec.Hostname)
+	}
+
+	s.mu.Lock()
+	s.batches[id] = b
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, spec := range specs {
+		wg.Add(1)
+		go func(spec provisioner.HostSpec) {
+			defer wg.Done()
+			if _, err := driver.Provision(ctx, spec); err != nil {
+				logger.Error("host provisioning failed", zap.String("hostname", spec.Hostname), zap.Error(err))
+			}
+		}(spec)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    id,
+		"hosts": b.hostname,
+	})
+}
+
+// handleBatchSubResource dispatches the /batches/{id}[/...] routes: the
+// batch summary itself, a single host's status, cancellation, and the SSE
+// log stream.
+func (s *Server) handleBatchSubResource(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/batches/")
+	parts := strings.SplitN(path, "/", 2)
+
+	s.mu.Lock()
+	b, ok := s.batches[parts[0]]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown batch id", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		s.handleBatchStatus(w, r, b)
+	case parts[1] == "cancel":
+		s.handleBatchCancel(w, r, b)
+	case parts[1] == "events":
+		s.handleBatchEvents(w, r, b)
+	case strings.HasPrefix(parts[1], "hosts/"):
+		s.handleHostStatus(w, r, b, strings.TrimPrefix(parts[1], "hosts/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleBatchStatus(w http.ResponseWriter, r *http.Request, b *batch) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	results := make([]*provisioner.Result, 0, len(b.hostname))
+	for _, hostname := range b.hostname {
+		result, err := b.driver.Status(hostname)
+		if err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    b.id,
+		"hosts": results,
+	})
+}
+
+func (s *Server) handleHostStatus(w http.ResponseWriter, r *http.Request, b *batch, hostname string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := b.driver.Status(hostname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleBatchCancel cancels every in-flight job in the batch. Jobs that
+// already finished are unaffected.
+func (s *Server) handleBatchCancel(w http.ResponseWriter, r *http.Request, b *batch) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cancelled := 0
+	for _, hostname := range b.hostname {
+		if b.driver.Cancel(hostname) {
+			cancelled++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"cancelled": cancelled})
+}
+
+// handleBatchEvents streams the batch's log lines as they're produced, one
+// SSE "data:" frame per line, until the client disconnects.
+func (s *Server) handleBatchEvents(w http.ResponseWriter, r *http.Request, b *batch) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := b.hub.subscribe()
+	defer b.hub.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", strings.TrimRight(line, "\n"))
+			flusher.Flush()
+		}
+	}
+}
+
+// newBatchID returns a short random hex identifier for a batch.
+func newBatchID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", buf), nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewRejectsEmptyToken(t *testing.T) {
+	if _, err := New(zap.NewNop(), ""); err == nil {
+		t.Fatal("New(token=\"\") = nil error, want error")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	s, err := New(zap.NewNop(), "s3cr3t")
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := s.requireAuth(next)
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantStatus int
+		wantCalled bool
+	}{
+		{name: "correct token", authHeader: "Bearer s3cr3t", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "wrong token", authHeader: "Bearer nope", wantStatus: http.StatusUnauthorized},
+		{name: "missing header", authHeader: "", wantStatus: http.StatusUnauthorized},
+		{name: "missing Bearer prefix", authHeader: "s3cr3t", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(http.MethodGet, "/batches", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("next handler called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}